@@ -0,0 +1,69 @@
+// Package committer patches only the namespace fields a controller owns, via a scoped JSON merge patch.
+package committer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// NamespacePatch describes the namespace fields a controller owns
+type NamespacePatch struct {
+	Name        string
+	Annotations map[string]string
+	Finalizers  []string
+}
+
+func (p *NamespacePatch) toDoc() map[string]interface{} {
+	metadata := map[string]interface{}{}
+	if p.Annotations != nil {
+		metadata["annotations"] = p.Annotations
+	}
+	if p.Finalizers != nil {
+		metadata["finalizers"] = p.Finalizers
+	}
+	return map[string]interface{}{"metadata": metadata}
+}
+
+// NamespaceCommitter patches exactly the namespace fields described by NamespacePatch.
+type NamespaceCommitter struct {
+	client corev1client.NamespaceInterface
+}
+
+// NewNamespaceCommitter returns a NamespaceCommitter that patches namespaces through client.
+func NewNamespaceCommitter(client corev1client.NamespaceInterface) *NamespaceCommitter {
+	return &NamespaceCommitter{client: client}
+}
+
+// Commit diffs oldPatch and newPatch and, if they differ, patches the namespace they name
+func (c *NamespaceCommitter) Commit(ctx context.Context, oldPatch, newPatch *NamespacePatch) error {
+	if oldPatch.Name != newPatch.Name {
+		return fmt.Errorf("committer: old and new NamespacePatch must name the same namespace, got %q and %q", oldPatch.Name, newPatch.Name)
+	}
+
+	oldJSON, err := json.Marshal(oldPatch.toDoc())
+	if err != nil {
+		return err
+	}
+	newJSON, err := json.Marshal(newPatch.toDoc())
+	if err != nil {
+		return err
+	}
+
+	patch, err := jsonpatch.CreateMergePatch(oldJSON, newJSON)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(patch, []byte("{}")) {
+		return nil
+	}
+
+	_, err = c.client.Patch(ctx, oldPatch.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
@@ -11,26 +12,25 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/serializer"
-	runtimejson "k8s.io/apimachinery/pkg/runtime/serializer/json"
-	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
-	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	"k8s.io/apimachinery/pkg/util/wait"
 	corev1informers "k8s.io/client-go/informers/core/v1"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	corev1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/client-go/util/workqueue"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/klog/v2"
 	coreapi "k8s.io/kubernetes/pkg/apis/core"
 
 	securityv1 "github.com/openshift/api/security/v1"
 	securityinternalv1 "github.com/openshift/api/securityinternal/v1"
 	securityv1client "github.com/openshift/client-go/securityinternal/clientset/versioned/typed/securityinternal/v1"
+	"github.com/openshift/cluster-policy-controller/pkg/security/committer"
 	"github.com/openshift/cluster-policy-controller/pkg/security/mcs"
 	"github.com/openshift/cluster-policy-controller/pkg/security/uidallocator"
 	"github.com/openshift/library-go/pkg/security/uid"
@@ -40,8 +40,27 @@ const (
 	controllerName    = "namespace-security-allocation-controller"
 	rangeName         = "scc-uid"
 	periodicRepairKey = "__internal/periodicRepair"
+
+	// alarmsAnnotation stores the JSON-encoded list of active allocatorAlarms
+	alarmsAnnotation = "security.openshift.io/scc-uid-allocator-alarms"
+
+	alarmUIDRangeFull     = "UIDRangeFull"
+	alarmUIDRangeMismatch = "UIDRangeMismatch"
+	alarmRepairBacklog    = "RepairBacklog"
+
+	// repairBacklogThreshold is the number of consecutive Repair() failures before alarming
+	repairBacklogThreshold = 3
 )
 
+// rangeAllocationBackoff bounds updateRangeAllocationWithRetry's retries of a conflicting
+// scc-uid RangeAllocation update
+var rangeAllocationBackoff = wait.Backoff{
+	Duration: 10 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    5,
+}
+
 // NamespaceSCCAllocationController allocates uids/labels for namespaces
 type NamespaceSCCAllocationController struct {
 	requiredUIDRange          *uid.Range
@@ -50,13 +69,15 @@ type NamespaceSCCAllocationController struct {
 	nsListerSynced            cache.InformerSynced
 	currentUIDRangeAllocation *securityinternalv1.RangeAllocation
 
+	// consecutiveRepairFailures counts Repair() failures in a row, for the RepairBacklog alarm
+	consecutiveRepairFailures int
+
 	namespaceClient       corev1client.NamespaceInterface
 	rangeAllocationClient securityv1client.RangeAllocationsGetter
+	namespaceCommitter    *committer.NamespaceCommitter
 
 	queue workqueue.RateLimitingInterface
 
-	encoder runtime.Encoder
-
 	eventRecorder record.EventRecorder
 }
 
@@ -71,19 +92,16 @@ func NewNamespaceSCCAllocationController(
 
 	scheme := runtime.NewScheme()
 	utilruntime.Must(corev1.AddToScheme(scheme))
-	codecs := serializer.NewCodecFactory(scheme)
-	jsonSerializer := runtimejson.NewSerializer(runtimejson.DefaultMetaFactory, scheme, scheme, false)
-	encoder := codecs.WithoutConversion().EncoderForVersion(jsonSerializer, corev1.SchemeGroupVersion)
 
 	c := &NamespaceSCCAllocationController{
 		requiredUIDRange:      requiredUIDRange,
 		mcsAllocator:          mcs,
 		namespaceClient:       client,
 		rangeAllocationClient: rangeAllocationClient,
+		namespaceCommitter:    committer.NewNamespaceCommitter(client),
 		nsLister:              namespaceInformer.Lister(),
 		nsListerSynced:        namespaceInformer.Informer().HasSynced,
 		queue:                 workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), controllerName),
-		encoder:               encoder,
 		eventRecorder:         eventsBroadcaster.NewRecorder(scheme, corev1.EventSource{Component: controllerName}),
 	}
 
@@ -93,6 +111,7 @@ func NewNamespaceSCCAllocationController(
 			UpdateFunc: func(oldObj, newObj interface{}) {
 				c.enqueueNamespace(newObj)
 			},
+			DeleteFunc: c.enqueueNamespaceDeletion,
 		},
 		10*time.Minute,
 	)
@@ -142,96 +161,116 @@ func (c *NamespaceSCCAllocationController) syncNamespace(key string) error {
 }
 
 func (c *NamespaceSCCAllocationController) allocate(ns *corev1.Namespace) error {
-	// unless we affirmatively succeed, clear the local state to try again
-	success := false
-	defer func() {
-		if success {
-			return
-		}
-		c.currentUIDRangeAllocation = nil
-	}()
-
-	// if we don't have the current state, go get it
-	if c.currentUIDRangeAllocation == nil {
-		newRange, err := c.rangeAllocationClient.RangeAllocations().Get(context.TODO(), rangeName, metav1.GetOptions{})
-		if err != nil {
-			return err
-		}
-		c.currentUIDRangeAllocation = newRange
-	}
-
-	// do uid allocation.  We reserve the UID we want first, lock it in etcd, then update the namespace.
-	// We allocate by reading in a giant bit int bitmap (one bit per offset location), finding the next step,
-	// then calculating the offset location
-	uidRange, err := uid.ParseRange(c.currentUIDRangeAllocation.Range)
+	block, err := c.allocateBlock()
 	if err != nil {
 		return err
 	}
-	if !reflect.DeepEqual(*uidRange, *c.requiredUIDRange) {
-		return fmt.Errorf("conflicting UID range; expected %#v, got %#v", *c.requiredUIDRange, *uidRange)
-	}
-	allocatedBitMapInt := big.NewInt(0).SetBytes(c.currentUIDRangeAllocation.Data)
-	bitIndex, found := allocateNextContiguousBit(allocatedBitMapInt, int(uidRange.Size()))
-	if !found {
-		return fmt.Errorf("uid range exceeded")
-	}
-	allocatedBitMapInt = allocatedBitMapInt.SetBit(allocatedBitMapInt, bitIndex, 1)
-	newRangeAllocation := c.currentUIDRangeAllocation.DeepCopy()
-	newRangeAllocation.Data = allocatedBitMapInt.Bytes()
 
-	actualRangeAllocation, err := c.rangeAllocationClient.RangeAllocations().Update(context.TODO(), newRangeAllocation, metav1.UpdateOptions{})
-	if err != nil {
-		return err
-	}
-	c.currentUIDRangeAllocation = actualRangeAllocation
+	// Now modify the namespace, through the committer so the patch only touches owned annotations
+	oldPatch := &committer.NamespacePatch{Name: ns.Name, Annotations: ownedAnnotations(ns.Annotations)}
 
-	block, ok := uidRange.BlockAt(uint32(bitIndex))
-	if !ok {
-		return fmt.Errorf("%d not in range", bitIndex)
-	}
-
-	// Now modify the namespace
-	nsCopy := ns.DeepCopy()
-	if nsCopy.Annotations == nil {
-		nsCopy.Annotations = make(map[string]string)
-	}
-	nsCopy.Annotations[securityv1.UIDRangeAnnotation] = block.String()
-	nsCopy.Annotations[securityv1.SupplementalGroupsAnnotation] = block.String()
-	if _, ok := nsCopy.Annotations[securityv1.MCSAnnotation]; !ok {
+	newAnnotations := ownedAnnotations(ns.Annotations)
+	newAnnotations[securityv1.UIDRangeAnnotation] = block.String()
+	newAnnotations[securityv1.SupplementalGroupsAnnotation] = block.String()
+	if _, ok := newAnnotations[securityv1.MCSAnnotation]; !ok {
 		if label := c.mcsAllocator(block); label != nil {
-			nsCopy.Annotations[securityv1.MCSAnnotation] = label.String()
+			newAnnotations[securityv1.MCSAnnotation] = label.String()
 		}
 	}
-	nsCopyBytes, err := runtime.Encode(c.encoder, nsCopy)
-	if err != nil {
+	newPatch := &committer.NamespacePatch{Name: ns.Name, Annotations: newAnnotations}
+
+	if err := c.namespaceCommitter.Commit(context.TODO(), oldPatch, newPatch); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
 		return err
 	}
-	nsBytes, err := runtime.Encode(c.encoder, ns)
-	if err != nil {
-		return err
+	// emit event once per namespace.  There aren't many of these, but it will let us know how long it takes from namespace creation
+	// until the SCC ranges are created.  There is a suspicion that this takes a while.
+	c.eventRecorder.Eventf(c.namespaceReference(ns), corev1.EventTypeNormal, "CreatedSCCRanges", "created SCC ranges")
+
+	return nil
+}
+
+// ownedAnnotations returns a fresh map holding only the annotations this controller owns
+func ownedAnnotations(annotations map[string]string) map[string]string {
+	owned := make(map[string]string, 3)
+	for _, key := range []string{securityv1.UIDRangeAnnotation, securityv1.SupplementalGroupsAnnotation, securityv1.MCSAnnotation} {
+		if value, ok := annotations[key]; ok {
+			owned[key] = value
+		}
 	}
-	patchBytes, err := strategicpatch.CreateTwoWayMergePatch(nsBytes, nsCopyBytes, &corev1.Namespace{})
+	return owned
+}
+
+// allocateBlock claims the next free UID block from the scc-uid RangeAllocation, retrying
+// against a freshly-fetched bitmap whenever the Update conflicts with a concurrent worker
+func (c *NamespaceSCCAllocationController) allocateBlock() (uid.Block, error) {
+	var claimedBlock uid.Block
+
+	err := c.updateRangeAllocationWithRetry(func(current *securityinternalv1.RangeAllocation) (*securityinternalv1.RangeAllocation, error) {
+		uidRange, err := uid.ParseRange(current.Range)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(*uidRange, *c.requiredUIDRange) {
+			return nil, fmt.Errorf("conflicting UID range; expected %#v, got %#v", *c.requiredUIDRange, *uidRange)
+		}
+		allocatedBitMapInt := big.NewInt(0).SetBytes(current.Data)
+		bitIndex, found := allocateNextContiguousBit(allocatedBitMapInt, int(uidRange.Size()))
+		if !found {
+			return nil, fmt.Errorf("uid range exceeded")
+		}
+		block, ok := uidRange.BlockAt(uint32(bitIndex))
+		if !ok {
+			return nil, fmt.Errorf("%d not in range", bitIndex)
+		}
+		claimedBlock = block
+
+		allocatedBitMapInt = allocatedBitMapInt.SetBit(allocatedBitMapInt, bitIndex, 1)
+		next := current.DeepCopy()
+		next.Data = allocatedBitMapInt.Bytes()
+		return next, nil
+	})
 	if err != nil {
-		return err
+		return uid.Block{}, err
 	}
-	// use patch here not to conflict with other actors
-	_, err = c.namespaceClient.Patch(context.TODO(), ns.Name, types.StrategicMergePatchType, patchBytes, metav1.PatchOptions{})
-	if apierrors.IsNotFound(err) {
+	return claimedBlock, nil
+}
+
+// updateRangeAllocationWithRetry reads the scc-uid RangeAllocation, applies mutate to produce
+// the object to write back, and retries the whole read-modify-write on conflict. mutate may
+// return a nil RangeAllocation to signal there is nothing to write on this attempt.
+func (c *NamespaceSCCAllocationController) updateRangeAllocationWithRetry(mutate func(*securityinternalv1.RangeAllocation) (*securityinternalv1.RangeAllocation, error)) error {
+	return retry.OnError(rangeAllocationBackoff, apierrors.IsConflict, func() error {
+		current, err := c.rangeAllocationClient.RangeAllocations().Get(context.TODO(), rangeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		proposed, err := mutate(current)
+		if err != nil {
+			return err
+		}
+		if proposed == nil {
+			return nil
+		}
+		updated, err := c.rangeAllocationClient.RangeAllocations().Update(context.TODO(), proposed, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		c.currentUIDRangeAllocation = updated
 		return nil
-	}
-	if err != nil {
-		return err
-	}
-	// emit event once per namespace.  There aren't many of these, but it will let us know how long it takes from namespace creation
-	// until the SCC ranges are created.  There is a suspicion that this takes a while.
-	c.eventRecorder.Eventf(&corev1.ObjectReference{
+	})
+}
+
+// namespaceReference builds an object reference suitable for recording events against the given namespace.
+func (c *NamespaceSCCAllocationController) namespaceReference(ns *corev1.Namespace) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
 		Kind:      "Namespace",
 		Namespace: ns.Name,
 		Name:      ns.Name,
-	}, corev1.EventTypeNormal, "CreatedSCCRanges", "created SCC ranges")
-
-	success = true
-	return nil
+		UID:       ns.UID,
+	}
 }
 
 // allocateNextContiguousBit finds a free bit in the int and returns which one it is and whether it succeeded
@@ -269,12 +308,33 @@ func (c *NamespaceSCCAllocationController) WaitForRepair(stopCh <-chan struct{})
 	})
 }
 
+// Repair wraps repair() to raise or clear the RepairBacklog alarm based on consecutive failures
 func (c *NamespaceSCCAllocationController) Repair() error {
-	// TODO: (per smarterclayton) if Get() or List() is a weak consistency read,
+	err := c.repair()
+	if err != nil {
+		c.consecutiveRepairFailures++
+		if c.consecutiveRepairFailures > repairBacklogThreshold {
+			msg := fmt.Sprintf("Repair() has failed %d consecutive times, most recently: %v", c.consecutiveRepairFailures, err)
+			if alarmErr := c.setAlarm(alarmRepairBacklog, msg); alarmErr != nil {
+				utilruntime.HandleError(fmt.Errorf("failed to record %s alarm: %v", alarmRepairBacklog, alarmErr))
+			}
+		}
+		return err
+	}
+	c.consecutiveRepairFailures = 0
+	if alarmErr := c.clearAlarm(alarmRepairBacklog); alarmErr != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to clear %s alarm: %v", alarmRepairBacklog, alarmErr))
+	}
+	return nil
+}
+
+func (c *NamespaceSCCAllocationController) repair() error {
+	// (per smarterclayton) if Get() or List() is a weak consistency read,
 	// or if they are executed against different leaders,
 	// the ordering guarantee required to ensure no item is allocated twice is violated.
-	// List must return a ResourceVersion higher than the etcd index Get,
-	// and the release code must not release items that have allocated but not yet been created
+	// To guard against this we pin the namespace List below to a ResourceVersion no older
+	// than the one observed on this Get, so in-flight deletions are never missed and a
+	// block is never released out from under a namespace that is still present.
 	// See https://github.com/kubernetes/kubernetes/issues/8295
 
 	// get the curr so we have a resourceVersion to pin to
@@ -285,33 +345,58 @@ func (c *NamespaceSCCAllocationController) Repair() error {
 	}
 	if needCreate {
 		uidRange = &securityinternalv1.RangeAllocation{ObjectMeta: metav1.ObjectMeta{Name: rangeName}}
+	} else {
+		if err := c.checkRangeDrift(uidRange); err != nil {
+			utilruntime.HandleError(fmt.Errorf("failed to record %s alarm: %v", alarmUIDRangeMismatch, err))
+		}
+		// checkRangeDrift may have just written the alarm annotation itself, bumping
+		// ResourceVersion; re-Get so the bitmap Update below isn't built against a stale copy.
+		uidRange, err = c.rangeAllocationClient.RangeAllocations().Get(context.TODO(), rangeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
 	}
 
 	uids, err := uidallocator.NewInMemory(c.requiredUIDRange)
 	if err != nil {
 		return err
 	}
-	nsList, err := c.nsLister.List(labels.Everything())
+	nsList, err := c.namespaceClient.List(context.TODO(), metav1.ListOptions{
+		ResourceVersion:      uidRange.ResourceVersion,
+		ResourceVersionMatch: metav1.ResourceVersionMatchNotOlderThan,
+	})
 	if err != nil {
 		return err
 	}
-	for _, ns := range nsList {
+	for i := range nsList.Items {
+		ns := &nsList.Items[i]
 		value, ok := ns.Annotations[securityv1.UIDRangeAnnotation]
 		if !ok {
 			continue
 		}
 		block, err := uid.ParseBlock(value)
 		if err != nil {
+			msg := fmt.Sprintf("the annotated UID block %q could not be parsed; the expected range is %s: %v", value, c.requiredUIDRange, err)
+			c.eventRecorder.Event(c.namespaceReference(ns), corev1.EventTypeWarning, "UIDBlockUnparseable", msg)
 			continue
 		}
 
 		switch err := uids.Allocate(block); err {
 		case nil:
-		case uidallocator.ErrNotInRange, uidallocator.ErrAllocated:
+		case uidallocator.ErrNotInRange:
+			msg := fmt.Sprintf("the annotated UID block %s is not within the expected range %s and could not be reclaimed during repair", block, c.requiredUIDRange)
+			c.eventRecorder.Event(c.namespaceReference(ns), corev1.EventTypeWarning, "UIDBlockOutOfRange", msg)
+			continue
+		case uidallocator.ErrAllocated:
+			msg := fmt.Sprintf("the annotated UID block %s conflicts with a block already allocated from range %s and could not be reclaimed during repair", block, c.requiredUIDRange)
+			c.eventRecorder.Event(c.namespaceReference(ns), corev1.EventTypeWarning, "UIDBlockConflict", msg)
 			continue
 		case uidallocator.ErrFull:
 			msg := fmt.Sprintf("the UID range %s is full; you must widen the range in order to allocate more UIDs", c.requiredUIDRange)
 			c.eventRecorder.Event(uidRange, corev1.EventTypeWarning, "UIDRangeFull", msg)
+			if alarmErr := c.setAlarm(alarmUIDRangeFull, msg); alarmErr != nil {
+				utilruntime.HandleError(fmt.Errorf("failed to record %s alarm: %v", alarmUIDRangeFull, alarmErr))
+			}
 			return errors.New(msg)
 		default:
 			return fmt.Errorf("unable to allocate UID block %s for namespace %s due to an unknown error, exiting: %v", block, ns.Name, err)
@@ -329,16 +414,36 @@ func (c *NamespaceSCCAllocationController) Repair() error {
 		if _, err := c.rangeAllocationClient.RangeAllocations().Create(context.TODO(), uidRange, metav1.CreateOptions{}); err != nil {
 			return err
 		}
-		return nil
+	} else {
+		updated, err := c.rangeAllocationClient.RangeAllocations().Update(context.TODO(), uidRange, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		c.currentUIDRangeAllocation = updated
 	}
 
-	if _, err := c.rangeAllocationClient.RangeAllocations().Update(context.TODO(), uidRange, metav1.UpdateOptions{}); err != nil {
-		return err
+	if alarmErr := c.clearAlarm(alarmUIDRangeFull); alarmErr != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to clear %s alarm: %v", alarmUIDRangeFull, alarmErr))
 	}
-
 	return nil
 }
 
+// checkRangeDrift sets or clears the UIDRangeMismatch alarm depending on whether the stored
+// UID range still matches requiredUIDRange
+func (c *NamespaceSCCAllocationController) checkRangeDrift(rangeAllocation *securityinternalv1.RangeAllocation) error {
+	existingRange, parseErr := uid.ParseRange(rangeAllocation.Range)
+	if parseErr == nil && reflect.DeepEqual(*existingRange, *c.requiredUIDRange) {
+		return c.clearAlarm(alarmUIDRangeMismatch)
+	}
+	var msg string
+	if parseErr != nil {
+		msg = fmt.Sprintf("the stored UID range %q could not be parsed: %v", rangeAllocation.Range, parseErr)
+	} else {
+		msg = fmt.Sprintf("the stored UID range %s no longer matches the configured range %s", existingRange, c.requiredUIDRange)
+	}
+	return c.setAlarm(alarmUIDRangeMismatch, msg)
+}
+
 type MCSAllocationFunc func(uid.Block) *mcs.Label
 
 // DefaultMCSAllocation returns a label from the MCS range that matches the offset
@@ -367,6 +472,163 @@ func (c *NamespaceSCCAllocationController) enqueueNamespace(obj interface{}) {
 	c.queue.Add(ns.Name)
 }
 
+// namespaceDeletion carries the block needed to release a deleted namespace's UID allocation
+type namespaceDeletion struct {
+	name  string
+	block string
+}
+
+func (c *NamespaceSCCAllocationController) enqueueNamespaceDeletion(obj interface{}) {
+	ns, ok := obj.(*corev1.Namespace)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("couldn't get object from tombstone %#v", obj))
+			return
+		}
+		ns, ok = tombstone.Obj.(*corev1.Namespace)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("tombstone contained object that is not a Namespace %#v", obj))
+			return
+		}
+	}
+	block, ok := ns.Annotations[securityv1.UIDRangeAnnotation]
+	if !ok {
+		return
+	}
+	c.queue.Add(namespaceDeletion{name: ns.Name, block: block})
+}
+
+// releaseNamespace parses the UID block captured at delete time and reclaims it.
+func (c *NamespaceSCCAllocationController) releaseNamespace(deletion namespaceDeletion) error {
+	block, err := uid.ParseBlock(deletion.block)
+	if err != nil {
+		// drop rather than retry forever; the next periodic Repair will reconcile the bitmap
+		utilruntime.HandleError(fmt.Errorf("unable to parse UID block %q for deleted namespace %s, skipping release: %v", deletion.block, deletion.name, err))
+		return nil
+	}
+	return c.release(deletion.name, block)
+}
+
+// release clears the bit backing block on the scc-uid RangeAllocation, retrying on conflict
+func (c *NamespaceSCCAllocationController) release(nsName string, block uid.Block) error {
+	return c.updateRangeAllocationWithRetry(func(rangeAllocation *securityinternalv1.RangeAllocation) (*securityinternalv1.RangeAllocation, error) {
+		uidRange, err := uid.ParseRange(rangeAllocation.Range)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(*uidRange, *c.requiredUIDRange) {
+			// the range has moved on since this namespace was allocated a block; it is no longer ours to release
+			klog.V(4).Infof("uid range changed from %s to %s, skipping release of block for namespace %s", uidRange, c.requiredUIDRange, nsName)
+			return nil, nil
+		}
+		ok, offset := uidRange.Offset(block)
+		if !ok {
+			klog.V(4).Infof("uid block %s for namespace %s no longer fits range %s, skipping release", block, nsName, uidRange)
+			return nil, nil
+		}
+		allocatedBitMapInt := big.NewInt(0).SetBytes(rangeAllocation.Data)
+		if allocatedBitMapInt.Bit(int(offset)) == 0 {
+			// already released, e.g. by a concurrent Repair() or a retried delete event
+			return nil, nil
+		}
+		allocatedBitMapInt = allocatedBitMapInt.SetBit(allocatedBitMapInt, int(offset), 0)
+
+		next := rangeAllocation.DeepCopy()
+		next.Data = allocatedBitMapInt.Bytes()
+		return next, nil
+	})
+}
+
+// alarmActiveGauge reports, per reason, whether an alarm is currently active (1) or cleared (0)
+var alarmActiveGauge = metrics.NewGaugeVec(&metrics.GaugeOpts{
+	Name:           "scc_uid_allocator_alarms",
+	Help:           "Whether a given SCC UID allocator alarm reason is currently active (1) or cleared (0).",
+	StabilityLevel: metrics.ALPHA,
+}, []string{"reason"})
+
+func init() {
+	legacyregistry.MustRegister(alarmActiveGauge)
+}
+
+// allocatorAlarm records a single persistent condition against the scc-uid RangeAllocation
+type allocatorAlarm struct {
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func decodeAlarms(rangeAllocation *securityinternalv1.RangeAllocation) ([]allocatorAlarm, error) {
+	raw, ok := rangeAllocation.Annotations[alarmsAnnotation]
+	if !ok || len(raw) == 0 {
+		return nil, nil
+	}
+	var alarms []allocatorAlarm
+	if err := json.Unmarshal([]byte(raw), &alarms); err != nil {
+		return nil, fmt.Errorf("unable to parse %s annotation: %v", alarmsAnnotation, err)
+	}
+	return alarms, nil
+}
+
+// setAlarm persists reason as an active alarm on the scc-uid RangeAllocation
+func (c *NamespaceSCCAllocationController) setAlarm(reason, message string) error {
+	return c.updateAlarms(reason, true, func(alarms []allocatorAlarm) []allocatorAlarm {
+		for i := range alarms {
+			if alarms[i].Reason == reason {
+				alarms[i].Message = message
+				return alarms
+			}
+		}
+		return append(alarms, allocatorAlarm{Reason: reason, Message: message, Timestamp: time.Now()})
+	})
+}
+
+// clearAlarm removes reason from the scc-uid RangeAllocation's alarm set, if present.
+func (c *NamespaceSCCAllocationController) clearAlarm(reason string) error {
+	return c.updateAlarms(reason, false, func(alarms []allocatorAlarm) []allocatorAlarm {
+		remaining := make([]allocatorAlarm, 0, len(alarms))
+		for _, alarm := range alarms {
+			if alarm.Reason != reason {
+				remaining = append(remaining, alarm)
+			}
+		}
+		return remaining
+	})
+}
+
+// updateAlarms applies mutate to the alarm set on the scc-uid RangeAllocation, retrying on conflict
+func (c *NamespaceSCCAllocationController) updateAlarms(reason string, active bool, mutate func([]allocatorAlarm) []allocatorAlarm) error {
+	err := c.updateRangeAllocationWithRetry(func(rangeAllocation *securityinternalv1.RangeAllocation) (*securityinternalv1.RangeAllocation, error) {
+		alarms, err := decodeAlarms(rangeAllocation)
+		if err != nil {
+			return nil, err
+		}
+		updatedAlarms := mutate(alarms)
+		encoded, err := json.Marshal(updatedAlarms)
+		if err != nil {
+			return nil, err
+		}
+		if rangeAllocation.Annotations[alarmsAnnotation] == string(encoded) {
+			return nil, nil
+		}
+		next := rangeAllocation.DeepCopy()
+		if next.Annotations == nil {
+			next.Annotations = make(map[string]string)
+		}
+		next.Annotations[alarmsAnnotation] = string(encoded)
+		return next, nil
+	})
+	if err != nil {
+		return err
+	}
+	gaugeValue := 0.0
+	if active {
+		gaugeValue = 1.0
+	}
+	alarmActiveGauge.WithLabelValues(reason).Set(gaugeValue)
+	return nil
+}
+
 // worker runs a worker thread that just dequeues items, processes them, and marks them done.
 // It enforces that the syncHandler is never invoked concurrently with the same key.
 func (c *NamespaceSCCAllocationController) worker() {
@@ -395,6 +657,16 @@ func (c *NamespaceSCCAllocationController) work() bool {
 		return true
 	}
 
+	if deletion, ok := key.(namespaceDeletion); ok {
+		if err := c.releaseNamespace(deletion); err == nil {
+			c.queue.Forget(key)
+		} else {
+			utilruntime.HandleError(fmt.Errorf("error releasing UID block for deleted namespace, it will be retried: %v", err))
+			c.queue.AddRateLimited(key)
+		}
+		return true
+	}
+
 	if err := c.syncNamespace(key.(string)); err == nil {
 		// this means the request was successfully handled.  We should "forget" the item so that any retry
 		// later on is reset
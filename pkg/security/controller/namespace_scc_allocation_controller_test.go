@@ -0,0 +1,265 @@
+package controller
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+
+	securityinternalv1 "github.com/openshift/api/securityinternal/v1"
+	securityv1client "github.com/openshift/client-go/securityinternal/clientset/versioned/typed/securityinternal/v1"
+	"github.com/openshift/library-go/pkg/security/uid"
+)
+
+// fakeRangeAllocations is a minimal securityv1client.RangeAllocationInterface that lets a
+// single Update be rigged to return a conflict once, so allocateBlock's retry can be exercised
+// against a bitmap it has to re-fetch.
+type fakeRangeAllocations struct {
+	mu sync.Mutex
+
+	current      *securityinternalv1.RangeAllocation
+	updateCalls  int
+	conflictOnce bool
+}
+
+func (f *fakeRangeAllocations) Get(ctx context.Context, name string, opts metav1.GetOptions) (*securityinternalv1.RangeAllocation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.current.DeepCopy(), nil
+}
+
+func (f *fakeRangeAllocations) Create(ctx context.Context, rangeAllocation *securityinternalv1.RangeAllocation, opts metav1.CreateOptions) (*securityinternalv1.RangeAllocation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.current = rangeAllocation.DeepCopy()
+	return f.current.DeepCopy(), nil
+}
+
+func (f *fakeRangeAllocations) Update(ctx context.Context, rangeAllocation *securityinternalv1.RangeAllocation, opts metav1.UpdateOptions) (*securityinternalv1.RangeAllocation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updateCalls++
+	if f.conflictOnce {
+		f.conflictOnce = false
+		return nil, apierrors.NewConflict(schema.GroupResource{Resource: "rangeallocations"}, rangeAllocation.Name, nil)
+	}
+	if rangeAllocation.ResourceVersion != f.current.ResourceVersion {
+		return nil, apierrors.NewConflict(schema.GroupResource{Resource: "rangeallocations"}, rangeAllocation.Name, nil)
+	}
+	updated := rangeAllocation.DeepCopy()
+	updated.ResourceVersion = nextResourceVersion(f.current.ResourceVersion)
+	f.current = updated
+	return f.current.DeepCopy(), nil
+}
+
+func (f *fakeRangeAllocations) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return nil
+}
+
+func (f *fakeRangeAllocations) DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error {
+	return nil
+}
+
+func (f *fakeRangeAllocations) List(ctx context.Context, opts metav1.ListOptions) (*securityinternalv1.RangeAllocationList, error) {
+	return &securityinternalv1.RangeAllocationList{}, nil
+}
+
+func (f *fakeRangeAllocations) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	return watch.NewEmptyWatch(), nil
+}
+
+func (f *fakeRangeAllocations) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*securityinternalv1.RangeAllocation, error) {
+	return f.current.DeepCopy(), nil
+}
+
+func nextResourceVersion(rv string) string {
+	if rv == "" {
+		return "1"
+	}
+	return rv + "0"
+}
+
+// fakeRangeAllocationsGetter adapts a fakeRangeAllocations to securityv1client.RangeAllocationsGetter.
+type fakeRangeAllocationsGetter struct {
+	ranges *fakeRangeAllocations
+}
+
+func (g *fakeRangeAllocationsGetter) RangeAllocations() securityv1client.RangeAllocationInterface {
+	return g.ranges
+}
+
+// TestAllocateBlockRetriesOnConflict verifies that when the RangeAllocation Update conflicts
+// on the first attempt, allocateBlock retries the Update instead of returning the conflict to
+// the caller, and that the bitmap it finally writes has the claimed block's bit set.
+func TestAllocateBlockRetriesOnConflict(t *testing.T) {
+	requiredRange, err := uid.ParseRange("1000000000/100000")
+	if err != nil {
+		t.Fatalf("unable to parse range: %v", err)
+	}
+
+	ranges := &fakeRangeAllocations{
+		current: &securityinternalv1.RangeAllocation{
+			ObjectMeta: metav1.ObjectMeta{Name: rangeName, ResourceVersion: "1"},
+			Range:      requiredRange.String(),
+			Data:       []byte{},
+		},
+		conflictOnce: true,
+	}
+
+	c := &NamespaceSCCAllocationController{
+		requiredUIDRange:      requiredRange,
+		rangeAllocationClient: &fakeRangeAllocationsGetter{ranges: ranges},
+	}
+
+	block, err := c.allocateBlock()
+	if err != nil {
+		t.Fatalf("allocateBlock returned an unexpected error: %v", err)
+	}
+	if ranges.conflictOnce {
+		t.Fatalf("expected the injected conflict to have been consumed")
+	}
+	if ranges.updateCalls < 2 {
+		t.Fatalf("expected allocateBlock to retry the Update after the conflict, got %d call(s)", ranges.updateCalls)
+	}
+	if c.currentUIDRangeAllocation == nil {
+		t.Fatalf("expected currentUIDRangeAllocation to be cached after a successful allocation")
+	}
+
+	ok, offset := requiredRange.Offset(block)
+	if !ok {
+		t.Fatalf("allocated block %v is not within the required range", block)
+	}
+	allocated := big.NewInt(0).SetBytes(c.currentUIDRangeAllocation.Data)
+	if allocated.Bit(int(offset)) != 1 {
+		t.Fatalf("expected the bit for offset %d to be set on the bitmap re-fetched after the conflict", offset)
+	}
+}
+
+// TestReleaseRetriesOnConflict verifies that release, like allocateBlock, retries its Update
+// when it conflicts on the first attempt, and that the bitmap it finally writes has the bit cleared.
+func TestReleaseRetriesOnConflict(t *testing.T) {
+	requiredRange, err := uid.ParseRange("1000000000/100000")
+	if err != nil {
+		t.Fatalf("unable to parse range: %v", err)
+	}
+	block, ok := requiredRange.BlockAt(0)
+	if !ok {
+		t.Fatalf("block 0 is not within the required range")
+	}
+
+	allocated := big.NewInt(0).SetBit(big.NewInt(0), 0, 1)
+	ranges := &fakeRangeAllocations{
+		current: &securityinternalv1.RangeAllocation{
+			ObjectMeta: metav1.ObjectMeta{Name: rangeName, ResourceVersion: "1"},
+			Range:      requiredRange.String(),
+			Data:       allocated.Bytes(),
+		},
+		conflictOnce: true,
+	}
+
+	c := &NamespaceSCCAllocationController{
+		requiredUIDRange:      requiredRange,
+		rangeAllocationClient: &fakeRangeAllocationsGetter{ranges: ranges},
+	}
+
+	if err := c.release("some-namespace", block); err != nil {
+		t.Fatalf("release returned an unexpected error: %v", err)
+	}
+	if ranges.conflictOnce {
+		t.Fatalf("expected the injected conflict to have been consumed")
+	}
+	if ranges.updateCalls < 2 {
+		t.Fatalf("expected release to retry the Update after the conflict, got %d call(s)", ranges.updateCalls)
+	}
+
+	remaining := big.NewInt(0).SetBytes(ranges.current.Data)
+	if remaining.Bit(0) != 0 {
+		t.Fatalf("expected the bit for the released block to be cleared on the bitmap written after the conflict")
+	}
+}
+
+// TestReleaseSkipsAlreadyClearedBit verifies the double-free guard: release is a no-op, and
+// does not write at all, when the bit is already clear.
+func TestReleaseSkipsAlreadyClearedBit(t *testing.T) {
+	requiredRange, err := uid.ParseRange("1000000000/100000")
+	if err != nil {
+		t.Fatalf("unable to parse range: %v", err)
+	}
+	block, ok := requiredRange.BlockAt(0)
+	if !ok {
+		t.Fatalf("block 0 is not within the required range")
+	}
+
+	ranges := &fakeRangeAllocations{
+		current: &securityinternalv1.RangeAllocation{
+			ObjectMeta: metav1.ObjectMeta{Name: rangeName, ResourceVersion: "1"},
+			Range:      requiredRange.String(),
+			Data:       []byte{},
+		},
+	}
+
+	c := &NamespaceSCCAllocationController{
+		requiredUIDRange:      requiredRange,
+		rangeAllocationClient: &fakeRangeAllocationsGetter{ranges: ranges},
+	}
+
+	if err := c.release("some-namespace", block); err != nil {
+		t.Fatalf("release returned an unexpected error: %v", err)
+	}
+	if ranges.updateCalls != 0 {
+		t.Fatalf("expected release to skip the write for an already-cleared bit, got %d Update call(s)", ranges.updateCalls)
+	}
+}
+
+// TestReleaseSkipsStaleRange verifies that release is a no-op when the scc-uid RangeAllocation
+// no longer matches requiredUIDRange, since the block is no longer this controller's to release.
+func TestReleaseSkipsStaleRange(t *testing.T) {
+	requiredRange, err := uid.ParseRange("1000000000/100000")
+	if err != nil {
+		t.Fatalf("unable to parse range: %v", err)
+	}
+	storedRange, err := uid.ParseRange("2000000000/100000")
+	if err != nil {
+		t.Fatalf("unable to parse range: %v", err)
+	}
+	block, ok := requiredRange.BlockAt(0)
+	if !ok {
+		t.Fatalf("block 0 is not within the required range")
+	}
+
+	allocated := big.NewInt(0).SetBit(big.NewInt(0), 0, 1)
+	ranges := &fakeRangeAllocations{
+		current: &securityinternalv1.RangeAllocation{
+			ObjectMeta: metav1.ObjectMeta{Name: rangeName, ResourceVersion: "1"},
+			Range:      storedRange.String(),
+			Data:       allocated.Bytes(),
+		},
+	}
+
+	c := &NamespaceSCCAllocationController{
+		requiredUIDRange:      requiredRange,
+		rangeAllocationClient: &fakeRangeAllocationsGetter{ranges: ranges},
+	}
+
+	if err := c.release("some-namespace", block); err != nil {
+		t.Fatalf("release returned an unexpected error: %v", err)
+	}
+	if ranges.updateCalls != 0 {
+		t.Fatalf("expected release to skip the write when the stored range has moved on, got %d Update call(s)", ranges.updateCalls)
+	}
+}
+
+// TestReleaseNamespaceDropsUnparseableBlock verifies releaseNamespace drops, rather than
+// retries, a deletion whose captured UID block annotation can no longer be parsed.
+func TestReleaseNamespaceDropsUnparseableBlock(t *testing.T) {
+	c := &NamespaceSCCAllocationController{}
+	if err := c.releaseNamespace(namespaceDeletion{name: "some-namespace", block: "not-a-block"}); err != nil {
+		t.Fatalf("releaseNamespace returned an unexpected error for an unparseable block: %v", err)
+	}
+}